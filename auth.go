@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token config, alongside the ClickHouse connection constants in main.go.
+const (
+	jwtPublicKeyPath = "/etc/chapi/jwt_public.pem"
+	jwtIssuer        = "chapi"
+	jwtAudience      = "chapi-clients"
+)
+
+// ErrEventTypeForbidden marks an event_type that a token's claims don't
+// permit, so callers can tell it apart from an ordinary validation error and
+// respond 403 instead of 400.
+var ErrEventTypeForbidden = errors.New("event_type not permitted for this token")
+
+// currentJWTKey holds the ed25519 public key used to verify bearer tokens.
+// It's stored behind an atomic.Value so rotateSigningKey can swap it in
+// without a server restart or a lock around every request.
+var currentJWTKey atomic.Value // ed25519.PublicKey
+
+// Claims are the per-tenant claims chapi expects in a bearer token.
+type Claims struct {
+	TenantID          string   `json:"tenant_id"`
+	AllowedEventTypes []string `json:"allowed_event_types"`
+	MaxRows           int      `json:"max_rows"`
+	jwt.RegisteredClaims
+}
+
+type claimsContextKey struct{}
+
+// loadJWTPublicKey reads a PEM file from path whose first block holds the raw
+// 32-byte ed25519 public key (a PKIX-encoded key is rejected) and installs it
+// as the active verification key.
+func loadJWTPublicKey(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return errors.New("auth: no PEM block found in " + path)
+	}
+	if len(block.Bytes) != ed25519.PublicKeySize {
+		return errors.New("auth: unexpected public key size in " + path)
+	}
+	currentJWTKey.Store(ed25519.PublicKey(block.Bytes))
+	return nil
+}
+
+// rotateSigningKey reloads the verification key from path. Call this from a
+// SIGHUP handler or an admin endpoint when the signing key is rotated;
+// in-flight requests keep using the key that was active when they started.
+func rotateSigningKey(path string) error {
+	return loadJWTPublicKey(path)
+}
+
+func activeJWTKey() (ed25519.PublicKey, error) {
+	key, _ := currentJWTKey.Load().(ed25519.PublicKey)
+	if key == nil {
+		return nil, errors.New("auth: no JWT public key loaded")
+	}
+	return key, nil
+}
+
+// authMiddleware validates the Bearer token on every request and injects its
+// claims into the request context so downstream handlers can scope queries
+// to the caller's tenant.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		tokenStr, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || tokenStr == "" {
+			writeJSON(w, http.StatusUnauthorized, APIResponse{Error: "missing bearer token"})
+			return
+		}
+
+		claims := &Claims{}
+		_, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (any, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodEd25519); !ok {
+				return nil, errors.New("unexpected signing method")
+			}
+			return activeJWTKey()
+		}, jwt.WithIssuer(jwtIssuer), jwt.WithAudience(jwtAudience))
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, APIResponse{Error: "invalid token: " + err.Error()})
+			return
+		}
+		if claims.TenantID == "" {
+			writeJSON(w, http.StatusUnauthorized, APIResponse{Error: "token missing tenant_id claim"})
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsContextKey{}, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// claimsFromContext retrieves the Claims injected by authMiddleware.
+func claimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*Claims)
+	return claims, ok
+}
+
+// clampLimit intersects a client-requested limit with the token's max_rows.
+func clampLimit(limit int, claims *Claims) int {
+	if claims != nil && claims.MaxRows > 0 && (limit <= 0 || limit > claims.MaxRows) {
+		return claims.MaxRows
+	}
+	return limit
+}
+
+// allowedEventType reports whether a tenant's token permits querying
+// eventType. An empty AllowedEventTypes list means the tenant may query any
+// event type within its own rows.
+func allowedEventType(claims *Claims, eventType string) bool {
+	if claims == nil || len(claims.AllowedEventTypes) == 0 {
+		return true
+	}
+	for _, et := range claims.AllowedEventTypes {
+		if et == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// intersectEventTypes restricts requested to the tenant's AllowedEventTypes.
+// If requested is empty, the tenant's full allow-list is used instead so the
+// query never silently spans every event type.
+func intersectEventTypes(requested []string, claims *Claims) ([]string, error) {
+	if claims == nil || len(claims.AllowedEventTypes) == 0 {
+		return requested, nil
+	}
+	if len(requested) == 0 {
+		return claims.AllowedEventTypes, nil
+	}
+	out := make([]string, 0, len(requested))
+	for _, et := range requested {
+		if !allowedEventType(claims, et) {
+			return nil, fmt.Errorf("%w: %s", ErrEventTypeForbidden, et)
+		}
+		out = append(out, et)
+	}
+	return out, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestClampLimit(t *testing.T) {
+	claims := &Claims{MaxRows: 50}
+	if got := clampLimit(100, claims); got != 50 {
+		t.Errorf("expected limit clamped to 50, got %d", got)
+	}
+	if got := clampLimit(10, claims); got != 10 {
+		t.Errorf("expected limit left at 10, got %d", got)
+	}
+	if got := clampLimit(100, nil); got != 100 {
+		t.Errorf("expected limit untouched with nil claims, got %d", got)
+	}
+}
+
+func TestAllowedEventType(t *testing.T) {
+	claims := &Claims{AllowedEventTypes: []string{"click", "view"}}
+	if !allowedEventType(claims, "click") {
+		t.Error("expected click to be allowed")
+	}
+	if allowedEventType(claims, "purchase") {
+		t.Error("expected purchase to be disallowed")
+	}
+	if !allowedEventType(nil, "anything") {
+		t.Error("expected nil claims to allow any event type")
+	}
+	if !allowedEventType(&Claims{}, "anything") {
+		t.Error("expected empty allow-list to allow any event type")
+	}
+}
+
+func TestIntersectEventTypes(t *testing.T) {
+	claims := &Claims{AllowedEventTypes: []string{"click", "view"}}
+
+	got, err := intersectEventTypes(nil, claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("expected claims allow-list to be used, got %v", got)
+	}
+
+	got, err = intersectEventTypes([]string{"click"}, claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0] != "click" {
+		t.Errorf("unexpected result: %v", got)
+	}
+
+	if _, err := intersectEventTypes([]string{"purchase"}, claims); err == nil {
+		t.Error("expected error for disallowed event type")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/line-protocol/v2/lineprotocol"
+)
+
+// Write subsystem config.
+const (
+	writeBatchSize       = 5000
+	writeFlushInterval   = 2 * time.Second
+	writeQueueDepth      = 4 * writeBatchSize
+	maxWritePayloadBytes = 4 << 20 // 4MiB
+)
+
+// writer is the process-wide batcher backing POST /api/facts/write. It's
+// started once from main, the same way conn is set up.
+var writer *writeBatcher
+
+// writeBatcher buffers incoming FactRows and flushes them to ClickHouse in
+// batches, either when writeBatchSize rows have queued up or every
+// writeFlushInterval, whichever comes first.
+type writeBatcher struct {
+	rows    chan FactRow
+	done    chan struct{}
+	flushed chan struct{}
+}
+
+func newWriteBatcher() *writeBatcher {
+	return &writeBatcher{
+		rows:    make(chan FactRow, writeQueueDepth),
+		done:    make(chan struct{}),
+		flushed: make(chan struct{}),
+	}
+}
+
+// Enqueue adds a row to the pending batch. It returns an error instead of
+// blocking when the queue is full, so a slow ClickHouse insert applies
+// backpressure to callers rather than piling up unbounded memory.
+func (b *writeBatcher) Enqueue(row FactRow) error {
+	select {
+	case b.rows <- row:
+		return nil
+	default:
+		return fmt.Errorf("write queue full, try again")
+	}
+}
+
+// Run drains the queue into batches until ctx is cancelled. It's meant to be
+// started once, in its own goroutine, from main.
+func (b *writeBatcher) Run(ctx context.Context) {
+	defer close(b.flushed)
+
+	ticker := time.NewTicker(writeFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]FactRow, 0, writeBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := insertFactBatch(ctx, batch); err != nil {
+			log.Printf("write: failed to flush %d rows: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case row := <-b.rows:
+			batch = append(batch, row)
+			if len(batch) >= writeBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			flush()
+			return
+		}
+	}
+}
+
+func insertFactBatch(ctx context.Context, rows []FactRow) error {
+	batch, err := conn.PrepareBatch(ctx, "INSERT INTO facts (event_date, event_time, user_id, session_id, event_type, metric_name, metric_value, dimensions, tenant_id)")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		eventTime, err := time.Parse(time.RFC3339, row.EventTime)
+		if err != nil {
+			return fmt.Errorf("invalid event_time %q: %w", row.EventTime, err)
+		}
+		if err := batch.Append(eventTime, eventTime, row.UserID, row.SessionID,
+			row.EventType, row.MetricName, row.MetricVal, row.Dimensions, row.tenantID); err != nil {
+			return err
+		}
+	}
+	return batch.Send()
+}
+
+// parseLineProtocol decodes an InfluxDB line-protocol payload into FactRows.
+// The measurement becomes event_type; the user_id and session_id tags map to
+// their matching FactRow columns, every other tag becomes a Dimensions entry,
+// and the metric_name/metric_value fields map directly onto FactRow.
+func parseLineProtocol(data []byte, claims *Claims) ([]FactRow, error) {
+	dec := lineprotocol.NewDecoderWithBytes(data)
+	var rows []FactRow
+
+	for dec.Next() {
+		measurement, err := dec.Measurement()
+		if err != nil {
+			return nil, fmt.Errorf("invalid measurement: %w", err)
+		}
+
+		row := FactRow{
+			EventType:  string(measurement),
+			Dimensions: make(map[string]string),
+		}
+
+		for {
+			key, val, err := dec.NextTag()
+			if err != nil {
+				return nil, fmt.Errorf("invalid tag: %w", err)
+			}
+			if key == nil {
+				break
+			}
+			switch string(key) {
+			case "user_id":
+				uid, err := strconv.ParseUint(string(val), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid user_id tag: %w", err)
+				}
+				row.UserID = uid
+			case "session_id":
+				row.SessionID = string(val)
+			default:
+				row.Dimensions[string(key)] = string(val)
+			}
+		}
+
+		for {
+			key, val, err := dec.NextField()
+			if err != nil {
+				return nil, fmt.Errorf("invalid field: %w", err)
+			}
+			if key == nil {
+				break
+			}
+			switch string(key) {
+			case "metric_name":
+				if val.Kind() != lineprotocol.String {
+					return nil, fmt.Errorf("metric_name field must be a string")
+				}
+				row.MetricName = val.StringV()
+			case "metric_value":
+				switch val.Kind() {
+				case lineprotocol.Float:
+					row.MetricVal = val.FloatV()
+				case lineprotocol.Int:
+					row.MetricVal = float64(val.IntV())
+				case lineprotocol.Uint:
+					row.MetricVal = float64(val.UintV())
+				default:
+					return nil, fmt.Errorf("metric_value field must be numeric")
+				}
+			}
+		}
+
+		ts, err := dec.Time(lineprotocol.Nanosecond, time.Time{})
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %w", err)
+		}
+		if ts.IsZero() {
+			ts = time.Now().UTC()
+		}
+		row.EventDate = ts.Format("2006-01-02")
+		row.EventTime = ts.Format(time.RFC3339)
+
+		if claims != nil {
+			if !allowedEventType(claims, row.EventType) {
+				return nil, fmt.Errorf("event_type %q not permitted for this token", row.EventType)
+			}
+			row.tenantID = claims.TenantID
+		}
+
+		rows = append(rows, row)
+	}
+	if err := dec.Err(); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// POST /api/facts/write — accepts an InfluxDB line-protocol body and queues
+// it for batched insertion into the facts table.
+func handleFactsWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, APIResponse{Error: "method not allowed"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWritePayloadBytes)
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, APIResponse{Error: "payload too large"})
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	rows, err := parseLineProtocol(data, claims)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{Error: err.Error()})
+		return
+	}
+
+	for _, row := range rows {
+		if err := writer.Enqueue(row); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, APIResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestParseLineProtocol(t *testing.T) {
+	line := `click,user_id=42,session_id=sess-1,region=US metric_name="page_views",metric_value=1 1700000000000000000` + "\n"
+
+	rows, err := parseLineProtocol([]byte(line), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(rows))
+	}
+
+	row := rows[0]
+	if row.EventType != "click" {
+		t.Errorf("EventType = %q, want click", row.EventType)
+	}
+	if row.UserID != 42 {
+		t.Errorf("UserID = %d, want 42", row.UserID)
+	}
+	if row.SessionID != "sess-1" {
+		t.Errorf("SessionID = %q, want sess-1", row.SessionID)
+	}
+	if row.Dimensions["region"] != "US" {
+		t.Errorf("Dimensions[region] = %q, want US", row.Dimensions["region"])
+	}
+	if row.MetricName != "page_views" {
+		t.Errorf("MetricName = %q, want page_views", row.MetricName)
+	}
+	if row.MetricVal != 1 {
+		t.Errorf("MetricVal = %v, want 1", row.MetricVal)
+	}
+}
+
+func TestParseLineProtocolStampsTenantID(t *testing.T) {
+	line := `click,user_id=42 metric_name="page_views",metric_value=1 1700000000000000000` + "\n"
+	claims := &Claims{TenantID: "tenant-a"}
+
+	rows, err := parseLineProtocol([]byte(line), claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(rows))
+	}
+	if rows[0].tenantID != "tenant-a" {
+		t.Errorf("tenantID = %q, want tenant-a", rows[0].tenantID)
+	}
+}
+
+func TestParseLineProtocolBadMetricValue(t *testing.T) {
+	line := `click,user_id=42 metric_name="page_views",metric_value="oops" 1700000000000000000` + "\n"
+
+	if _, err := parseLineProtocol([]byte(line), nil); err == nil {
+		t.Error("expected error for non-numeric metric_value")
+	}
+}
+
+func TestParseLineProtocolDeniedEventType(t *testing.T) {
+	line := `purchase,user_id=1 metric_name="checkout",metric_value=1 1700000000000000000` + "\n"
+	claims := &Claims{AllowedEventTypes: []string{"click"}}
+
+	if _, err := parseLineProtocol([]byte(line), claims); err == nil {
+		t.Error("expected error for disallowed event_type")
+	}
+}
+
+func TestWriteBatcherBackpressure(t *testing.T) {
+	b := &writeBatcher{rows: make(chan FactRow, 1)}
+	if err := b.Enqueue(FactRow{}); err != nil {
+		t.Fatalf("unexpected error on first enqueue: %v", err)
+	}
+	if err := b.Enqueue(FactRow{}); err == nil {
+		t.Error("expected error once the queue is full")
+	}
+}
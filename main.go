@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -21,8 +23,30 @@ const (
 	chUser     = "default"
 	chPassword = ""
 	listenAddr = ":8080"
+
+	defaultQueryTimeout = 30 * time.Second
+	maxQueryTimeout     = 5 * time.Minute
 )
 
+// requestContext derives a query-scoped context from the incoming request so
+// that client disconnects and an optional ?timeout= override actually cancel
+// the in-flight ClickHouse query, instead of every handler running its query
+// against context.Background().
+func requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := defaultQueryTimeout
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		if d, err := time.ParseDuration(t); err == nil && d > 0 {
+			timeout = d
+		} else if secs, err := strconv.Atoi(t); err == nil && secs > 0 {
+			timeout = time.Duration(secs) * time.Second
+		}
+	}
+	if timeout > maxQueryTimeout {
+		timeout = maxQueryTimeout
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
 // FactRow represents a row from the fact table
 type FactRow struct {
 	EventDate  string            `json:"event_date"`
@@ -33,6 +57,12 @@ type FactRow struct {
 	MetricName string            `json:"metric_name"`
 	MetricVal  float64           `json:"metric_value"`
 	Dimensions map[string]string `json:"dimensions"`
+
+	// tenantID scopes a written row to the token that wrote it, so every
+	// read handler's unconditional "tenant_id = ?" filter can find it again.
+	// Unexported: read handlers never scan it back out, so it never leaks
+	// into a JSON response.
+	tenantID string
 }
 
 // QueryRequest represents a query from the client
@@ -46,6 +76,7 @@ type QueryRequest struct {
 	Filters    map[string]string `json:"filters"`     // dimension filters
 	Limit      int               `json:"limit"`
 	Offset     int               `json:"offset"`
+	Stats      string            `json:"stats,omitempty"` // "all" to attach query stats to the response
 }
 
 // AggResult for grouped queries
@@ -55,9 +86,55 @@ type AggResult struct {
 }
 
 type APIResponse struct {
-	Data  any    `json:"data"`
-	Count int    `json:"count"`
-	Error string `json:"error,omitempty"`
+	Data       any         `json:"data"`
+	Count      int         `json:"count"`
+	Error      string      `json:"error,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Stats      *QueryStats `json:"stats,omitempty"`
+}
+
+// factCursor is the decoded form of an opaque pagination token for handleFacts.
+// It captures the last row's (event_time, user_id) so a client can resume a
+// descending scan with a keyset predicate instead of an expensive OFFSET.
+type factCursor struct {
+	EventTime time.Time `json:"t"`
+	UserID    uint64    `json:"u"`
+}
+
+func encodeCursor(t time.Time, userID uint64) string {
+	data, _ := json.Marshal(factCursor{EventTime: t, UserID: userID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (factCursor, error) {
+	var c factCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// wantsNDJSON reports whether the client asked for a streamed NDJSON response
+// via the Accept header or the ?format= query param.
+func wantsNDJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "ndjson" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// wantsArrow reports whether the client asked for Arrow via ?format=arrow.
+//
+// TODO(jgmec/clickhouse_go_http_api#chunk0-1): arrow was part of that
+// request's literal "ndjson|arrow" scope but was never implemented — every
+// call below returns 501. Needs its own follow-up request rather than
+// staying a silent permanent stub.
+func wantsArrow(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "arrow"
 }
 
 var conn driver.Conn
@@ -89,10 +166,33 @@ func main() {
 	}
 	log.Println("Connected to ClickHouse")
 
-	http.HandleFunc("/api/facts", handleFacts)
-	http.HandleFunc("/api/facts/aggregate", handleAggregate)
-	http.HandleFunc("/api/facts/timeseries", handleTimeseries)
+	if err := loadJWTPublicKey(jwtPublicKeyPath); err != nil {
+		log.Fatal("cannot load JWT public key:", err)
+	}
+
+	writer = newWriteBatcher()
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go writer.Run(bgCtx)
+	startConnStatsCollector(bgCtx.Done())
+
+	rules := newRuleManager()
+
+	apiMux := http.NewServeMux()
+	apiMux.HandleFunc("/api/facts", instrumentRoute("facts", handleFacts))
+	apiMux.HandleFunc("/api/facts/aggregate", instrumentRoute("aggregate", handleAggregate))
+	apiMux.HandleFunc("/api/facts/timeseries", instrumentRoute("timeseries", handleTimeseries))
+	apiMux.HandleFunc("/api/facts/query_range", instrumentRoute("query_range", handleQueryRange))
+	apiMux.HandleFunc("/api/facts/query", instrumentRoute("query", handleQueryInstant))
+	apiMux.HandleFunc("/api/facts/write", instrumentRoute("write", handleFactsWrite))
+	apiMux.HandleFunc("/api/facts/rules", instrumentRoute("rules", handleRules(rules, bgCtx)))
+
+	http.Handle("/api/", authMiddleware(apiMux))
 	http.HandleFunc("/health", handleHealth)
+	// /metrics is internal operational telemetry, not tenant data (see the
+	// ruleValue comment in rules.go), but still requires a bearer token like
+	// the rest of the API rather than being open to anyone on the listener.
+	http.Handle("/metrics", authMiddleware(metricsHandler))
 
 	log.Printf("Listening on %s", listenAddr)
 	log.Fatal(http.ListenAndServe(listenAddr, nil))
@@ -105,6 +205,13 @@ func handleFacts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if wantsArrow(r) {
+		writeJSON(w, http.StatusNotImplemented, APIResponse{Error: "arrow format not yet supported"})
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
 	q := r.URL.Query()
 	dateFrom := q.Get("date_from")
 	dateTo := q.Get("date_to")
@@ -113,15 +220,28 @@ func handleFacts(w http.ResponseWriter, r *http.Request) {
 	limit, _ := strconv.Atoi(q.Get("limit"))
 	offset, _ := strconv.Atoi(q.Get("offset"))
 
+	if eventType != "" && !allowedEventType(claims, eventType) {
+		writeJSON(w, http.StatusForbidden, APIResponse{Error: "event_type not permitted for this token"})
+		return
+	}
+
 	if limit <= 0 || limit > 10000 {
 		limit = 100
 	}
+	limit = clampLimit(limit, claims)
 
-	// Build query with parameterized conditions
+	// Build query with parameterized conditions. tenant_id is always present
+	// and never derived from client input, so a forged query_range/filters
+	// payload cannot widen it.
 	var conditions []string
 	args := make([]any, 0)
 	argIdx := 0
 
+	if claims != nil {
+		conditions = append(conditions, "tenant_id = ?")
+		args = append(args, claims.TenantID)
+		argIdx++
+	}
 	if dateFrom != "" {
 		conditions = append(conditions, "event_date >= ?")
 		args = append(args, dateFrom)
@@ -136,6 +256,14 @@ func handleFacts(w http.ResponseWriter, r *http.Request) {
 		conditions = append(conditions, "event_type = ?")
 		args = append(args, eventType)
 		argIdx++
+	} else if claims != nil && len(claims.AllowedEventTypes) > 0 {
+		placeholders := make([]string, len(claims.AllowedEventTypes))
+		for i, et := range claims.AllowedEventTypes {
+			placeholders[i] = "?"
+			args = append(args, et)
+		}
+		conditions = append(conditions, "event_type IN ("+strings.Join(placeholders, ",")+")")
+		argIdx++
 	}
 	if userID != "" {
 		uid, err := strconv.ParseUint(userID, 10, 64)
@@ -146,21 +274,61 @@ func handleFacts(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// A cursor supersedes offset: it carries the (event_time, user_id) of the
+	// last row the client saw, letting us resume the descending scan with a
+	// keyset predicate instead of an ever-more-expensive OFFSET.
+	cursorTok := q.Get("cursor")
+	useCursor := cursorTok != ""
+	if useCursor {
+		c, err := decodeCursor(cursorTok)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, APIResponse{Error: "invalid cursor"})
+			return
+		}
+		conditions = append(conditions, "(event_time, user_id) < (?, ?)")
+		args = append(args, c.EventTime, c.UserID)
+		argIdx++
+		offset = 0
+	}
+
 	query := "SELECT event_date, event_time, user_id, session_id, event_type, metric_name, metric_value FROM facts"
 	if len(conditions) > 0 {
 		query += " WHERE " + strings.Join(conditions, " AND ")
 	}
-	query += fmt.Sprintf(" ORDER BY event_time DESC LIMIT %d OFFSET %d", limit, offset)
+	query += fmt.Sprintf(" ORDER BY event_time DESC, user_id DESC LIMIT %d", limit)
+	if !useCursor && offset > 0 {
+		query += fmt.Sprintf(" OFFSET %d", offset)
+	}
 
 	_ = argIdx
-	rows, err := conn.Query(context.Background(), query, args...)
+
+	ctx, cancel := requestContext(r)
+	defer cancel()
+
+	stats := &QueryStats{}
+	includeStats := wantsStats(r, "")
+
+	var rows driver.Rows
+	err := measureQuery(ctx, stats, func(qctx context.Context) error {
+		var qerr error
+		rows, qerr = conn.Query(qctx, query, args...)
+		return qerr
+	})
 	if err != nil {
+		recordQueryMetrics("facts", stats, 0, err)
 		writeJSON(w, http.StatusInternalServerError, APIResponse{Error: err.Error()})
 		return
 	}
 	defer rows.Close()
 
+	if wantsNDJSON(r) {
+		streamFactsNDJSON(w, rows, limit, stats, includeStats)
+		return
+	}
+
 	var results []FactRow
+	var lastEventTime time.Time
+	var lastUserID uint64
 	for rows.Next() {
 		var row FactRow
 		var eventDate time.Time
@@ -172,68 +340,126 @@ func handleFacts(w http.ResponseWriter, r *http.Request) {
 		}
 		row.EventDate = eventDate.Format("2006-01-02")
 		row.EventTime = eventTime.Format(time.RFC3339)
+		lastEventTime, lastUserID = eventTime, row.UserID
 		results = append(results, row)
 	}
 
-	writeJSON(w, http.StatusOK, APIResponse{Data: results, Count: len(results)})
-}
+	recordQueryMetrics("facts", stats, len(results), nil)
 
-// POST /api/facts/aggregate
-func handleAggregate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, APIResponse{Error: "method not allowed"})
-		return
+	resp := APIResponse{Data: results, Count: len(results)}
+	if len(results) == limit {
+		resp.NextCursor = encodeCursor(lastEventTime, lastUserID)
 	}
+	if includeStats {
+		resp.Stats = stats
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
 
-	var req QueryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, APIResponse{Error: "invalid request body"})
-		return
+// streamFactsNDJSON writes one FactRow per line as rows come off the driver,
+// instead of buffering the whole result set, and terminates with a line
+// carrying next_cursor once the page fills up. It still reports
+// chapi_clickhouse_query_duration_seconds/rows_returned and, if requested,
+// a trailing stats line — ndjson exports are exactly the large-response
+// traffic this telemetry exists to track.
+func streamFactsNDJSON(w http.ResponseWriter, rows driver.Rows, limit int, stats *QueryStats, includeStats bool) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	var n int
+	var lastEventTime time.Time
+	var lastUserID uint64
+	for rows.Next() {
+		var row FactRow
+		var eventDate time.Time
+		var eventTime time.Time
+		if err := rows.Scan(&eventDate, &eventTime, &row.UserID, &row.SessionID,
+			&row.EventType, &row.MetricName, &row.MetricVal); err != nil {
+			enc.Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		row.EventDate = eventDate.Format("2006-01-02")
+		row.EventTime = eventTime.Format(time.RFC3339)
+		lastEventTime, lastUserID = eventTime, row.UserID
+		n++
+
+		enc.Encode(row)
+		if flusher != nil {
+			flusher.Flush()
+		}
 	}
 
-	// Validate group by columns (whitelist to prevent injection)
-	allowedCols := map[string]bool{
-		"event_date": true, "event_type": true, "metric_name": true,
-		"user_id": true, "session_id": true,
+	recordQueryMetrics("facts", stats, n, nil)
+
+	if n == limit {
+		enc.Encode(map[string]string{"next_cursor": encodeCursor(lastEventTime, lastUserID)})
+	}
+	if includeStats {
+		enc.Encode(map[string]*QueryStats{"stats": stats})
 	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// aggregateAllowedCols whitelists columns that may appear in group_by to
+// prevent SQL injection via the JSON body.
+var aggregateAllowedCols = map[string]bool{
+	"event_date": true, "event_type": true, "metric_name": true,
+	"user_id": true, "session_id": true,
+}
+
+// aggregateAllowedMetrics whitelists the aggregate expressions selectable via
+// QueryRequest.Metrics.
+var aggregateAllowedMetrics = map[string]string{
+	"sum":   "sum(metric_value)",
+	"avg":   "avg(metric_value)",
+	"count": "count()",
+	"min":   "min(metric_value)",
+	"max":   "max(metric_value)",
+	"uniq":  "uniq(user_id)",
+}
+
+// buildAggregateQuery turns a QueryRequest into a parameterized SQL query,
+// scoping it to claims' tenant and allowed event types. It's shared by
+// handleAggregate and the rule evaluator so a saved rule behaves exactly like
+// an ad-hoc aggregate call.
+func buildAggregateQuery(req *QueryRequest, claims *Claims) (string, []any, error) {
 	for _, col := range req.GroupBy {
-		if !allowedCols[col] {
-			writeJSON(w, http.StatusBadRequest, APIResponse{Error: fmt.Sprintf("invalid group_by column: %s", col)})
-			return
+		if !aggregateAllowedCols[col] {
+			return "", nil, fmt.Errorf("invalid group_by column: %s", col)
 		}
 	}
 
-	// Validate metrics
-	allowedMetrics := map[string]string{
-		"sum":   "sum(metric_value)",
-		"avg":   "avg(metric_value)",
-		"count": "count()",
-		"min":   "min(metric_value)",
-		"max":   "max(metric_value)",
-		"uniq":  "uniq(user_id)",
-	}
 	if len(req.Metrics) == 0 {
 		req.Metrics = []string{"sum", "count"}
 	}
 
-	// Build SELECT
 	var selectParts []string
-	for _, col := range req.GroupBy {
-		selectParts = append(selectParts, col)
-	}
+	selectParts = append(selectParts, req.GroupBy...)
 	for _, m := range req.Metrics {
-		expr, ok := allowedMetrics[m]
+		expr, ok := aggregateAllowedMetrics[m]
 		if !ok {
-			writeJSON(w, http.StatusBadRequest, APIResponse{Error: fmt.Sprintf("invalid metric: %s", m)})
-			return
+			return "", nil, fmt.Errorf("invalid metric: %s", m)
 		}
 		selectParts = append(selectParts, fmt.Sprintf("%s AS %s", expr, m))
 	}
 
-	// Build WHERE
+	eventTypes, err := intersectEventTypes(req.EventTypes, claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// tenant_id is always present and derived from the verified token, never
+	// from the request body, so it can't be widened by a client.
 	var conditions []string
 	var args []any
 
+	if claims != nil {
+		conditions = append(conditions, "tenant_id = ?")
+		args = append(args, claims.TenantID)
+	}
 	if req.DateFrom != "" {
 		conditions = append(conditions, "event_date >= ?")
 		args = append(args, req.DateFrom)
@@ -242,9 +468,9 @@ func handleAggregate(w http.ResponseWriter, r *http.Request) {
 		conditions = append(conditions, "event_date <= ?")
 		args = append(args, req.DateTo)
 	}
-	if len(req.EventTypes) > 0 {
-		placeholders := make([]string, len(req.EventTypes))
-		for i, et := range req.EventTypes {
+	if len(eventTypes) > 0 {
+		placeholders := make([]string, len(eventTypes))
+		for i, et := range eventTypes {
 			placeholders[i] = "?"
 			args = append(args, et)
 		}
@@ -268,17 +494,64 @@ func handleAggregate(w http.ResponseWriter, r *http.Request) {
 	}
 	query += " ORDER BY " + req.Metrics[0] + " DESC"
 
+	// Apply the default/ceiling check before the tenant clamp, same order as
+	// handleFacts, so the same token gets the same cap on both endpoints.
 	limit := req.Limit
 	if limit <= 0 || limit > 10000 {
 		limit = 100
 	}
+	limit = clampLimit(limit, claims)
 	query += fmt.Sprintf(" LIMIT %d", limit)
 	if req.Offset > 0 {
 		query += fmt.Sprintf(" OFFSET %d", req.Offset)
 	}
 
-	rows, err := conn.Query(context.Background(), query, args...)
+	return query, args, nil
+}
+
+// POST /api/facts/aggregate
+func handleAggregate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, APIResponse{Error: "method not allowed"})
+		return
+	}
+
+	var req QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{Error: "invalid request body"})
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	query, args, err := buildAggregateQuery(&req, claims)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrEventTypeForbidden) {
+			status = http.StatusForbidden
+		}
+		writeJSON(w, status, APIResponse{Error: err.Error()})
+		return
+	}
+
+	if wantsArrow(r) {
+		writeJSON(w, http.StatusNotImplemented, APIResponse{Error: "arrow format not yet supported"})
+		return
+	}
+
+	ctx, cancel := requestContext(r)
+	defer cancel()
+
+	stats := &QueryStats{}
+	includeStats := wantsStats(r, req.Stats)
+
+	var rows driver.Rows
+	err = measureQuery(ctx, stats, func(qctx context.Context) error {
+		var qerr error
+		rows, qerr = conn.Query(qctx, query, args...)
+		return qerr
+	})
 	if err != nil {
+		recordQueryMetrics("aggregate", stats, 0, err)
 		writeJSON(w, http.StatusInternalServerError, APIResponse{Error: err.Error()})
 		return
 	}
@@ -287,6 +560,11 @@ func handleAggregate(w http.ResponseWriter, r *http.Request) {
 	colTypes := rows.ColumnTypes()
 	colNames := rows.Columns()
 
+	if wantsNDJSON(r) {
+		streamAggregateNDJSON(w, rows, colTypes, colNames, stats, includeStats)
+		return
+	}
+
 	var results []map[string]any
 	for rows.Next() {
 		vals := make([]any, len(colNames))
@@ -304,7 +582,53 @@ func handleAggregate(w http.ResponseWriter, r *http.Request) {
 		results = append(results, row)
 	}
 
-	writeJSON(w, http.StatusOK, APIResponse{Data: results, Count: len(results)})
+	recordQueryMetrics("aggregate", stats, len(results), nil)
+
+	resp := APIResponse{Data: results, Count: len(results)}
+	if includeStats {
+		resp.Stats = stats
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// streamAggregateNDJSON writes one grouped result row per line as rows come
+// off the driver, instead of buffering the whole result set into memory. It
+// still reports chapi_clickhouse_query_duration_seconds/rows_returned and, if
+// requested, a trailing stats line, same as the buffered JSON response.
+func streamAggregateNDJSON(w http.ResponseWriter, rows driver.Rows, colTypes []driver.ColumnType, colNames []string, stats *QueryStats, includeStats bool) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	var n int
+	for rows.Next() {
+		vals := make([]any, len(colNames))
+		for i, ct := range colTypes {
+			vals[i] = reflect(ct)
+		}
+		if err := rows.Scan(vals...); err != nil {
+			enc.Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		row := make(map[string]any)
+		for i, name := range colNames {
+			row[name] = deref(vals[i])
+		}
+		n++
+		enc.Encode(row)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	recordQueryMetrics("aggregate", stats, n, nil)
+
+	if includeStats {
+		enc.Encode(map[string]*QueryStats{"stats": stats})
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
 }
 
 // GET /api/facts/timeseries?date_from=...&date_to=...&event_type=...&metric=sum&granularity=day
@@ -326,6 +650,12 @@ func handleTimeseries(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	claims, _ := claimsFromContext(r.Context())
+	if eventType != "" && !allowedEventType(claims, eventType) {
+		writeJSON(w, http.StatusForbidden, APIResponse{Error: "event_type not permitted for this token"})
+		return
+	}
+
 	metricExpr := "sum(metric_value)"
 	switch metric {
 	case "avg":
@@ -349,12 +679,23 @@ func handleTimeseries(w http.ResponseWriter, r *http.Request) {
 	var conditions []string
 	var args []any
 
+	if claims != nil {
+		conditions = append(conditions, "tenant_id = ?")
+		args = append(args, claims.TenantID)
+	}
 	conditions = append(conditions, "event_date >= ?", "event_date <= ?")
 	args = append(args, dateFrom, dateTo)
 
 	if eventType != "" {
 		conditions = append(conditions, "event_type = ?")
 		args = append(args, eventType)
+	} else if claims != nil && len(claims.AllowedEventTypes) > 0 {
+		placeholders := make([]string, len(claims.AllowedEventTypes))
+		for i, et := range claims.AllowedEventTypes {
+			placeholders[i] = "?"
+			args = append(args, et)
+		}
+		conditions = append(conditions, "event_type IN ("+strings.Join(placeholders, ",")+")")
 	}
 
 	query := fmt.Sprintf(
@@ -362,8 +703,20 @@ func handleTimeseries(w http.ResponseWriter, r *http.Request) {
 		dateExpr, metricExpr, strings.Join(conditions, " AND "),
 	)
 
-	rows, err := conn.Query(context.Background(), query, args...)
+	ctx, cancel := requestContext(r)
+	defer cancel()
+
+	stats := &QueryStats{}
+	includeStats := wantsStats(r, "")
+
+	var rows driver.Rows
+	err := measureQuery(ctx, stats, func(qctx context.Context) error {
+		var qerr error
+		rows, qerr = conn.Query(qctx, query, args...)
+		return qerr
+	})
 	if err != nil {
+		recordQueryMetrics("timeseries", stats, 0, err)
 		writeJSON(w, http.StatusInternalServerError, APIResponse{Error: err.Error()})
 		return
 	}
@@ -385,11 +738,20 @@ func handleTimeseries(w http.ResponseWriter, r *http.Request) {
 		results = append(results, p)
 	}
 
-	writeJSON(w, http.StatusOK, APIResponse{Data: results, Count: len(results)})
+	recordQueryMetrics("timeseries", stats, len(results), nil)
+
+	resp := APIResponse{Data: results, Count: len(results)}
+	if includeStats {
+		resp.Stats = stats
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
-	err := conn.Ping(context.Background())
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	err := conn.Ping(ctx)
 	if err != nil {
 		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unhealthy", "error": err.Error()})
 		return
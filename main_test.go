@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -164,6 +165,46 @@ func TestDerefFunction(t *testing.T) {
 	}
 }
 
+func TestCursorRoundTrip(t *testing.T) {
+	want := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	tok := encodeCursor(want, 42)
+
+	c, err := decodeCursor(tok)
+	if err != nil {
+		t.Fatalf("decodeCursor failed: %v", err)
+	}
+	if !c.EventTime.Equal(want) {
+		t.Errorf("EventTime mismatch: got %v, want %v", c.EventTime, want)
+	}
+	if c.UserID != 42 {
+		t.Errorf("UserID mismatch: got %d, want %d", c.UserID, 42)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	if _, err := decodeCursor("not-valid-base64!!"); err == nil {
+		t.Error("expected error for invalid cursor token")
+	}
+}
+
+func TestWantsNDJSON(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/facts?format=ndjson", nil)
+	if !wantsNDJSON(req) {
+		t.Error("expected wantsNDJSON to be true for ?format=ndjson")
+	}
+
+	req, _ = http.NewRequest("GET", "/api/facts", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	if !wantsNDJSON(req) {
+		t.Error("expected wantsNDJSON to be true for Accept: application/x-ndjson")
+	}
+
+	req, _ = http.NewRequest("GET", "/api/facts", nil)
+	if wantsNDJSON(req) {
+		t.Error("expected wantsNDJSON to be false by default")
+	}
+}
+
 func TestFactRowMarshalling(t *testing.T) {
 	row := FactRow{
 		EventDate:  "2024-01-01",
@@ -190,3 +231,22 @@ func TestFactRowMarshalling(t *testing.T) {
 		t.Errorf("UserID mismatch: got %d, want %d", unmarshalled.UserID, row.UserID)
 	}
 }
+
+// TestBuildAggregateQueryLimitMatchesHandleFacts guards against the limit
+// clamp order drifting between handleFacts and buildAggregateQuery: both must
+// apply the default/10000-ceiling check before the tenant's max_rows clamp,
+// or the same token gets a different cap depending on which endpoint it hits.
+func TestBuildAggregateQueryLimitMatchesHandleFacts(t *testing.T) {
+	claims := &Claims{TenantID: "tenant-a", MaxRows: 3000}
+	req := QueryRequest{Metrics: []string{"sum"}, Limit: 50000}
+
+	query, _, err := buildAggregateQuery(&req, claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 50000 is over the ceiling, so it falls back to the default 100 first;
+	// 100 is under max_rows, so the tenant clamp leaves it there.
+	if !strings.Contains(query, "LIMIT 100") {
+		t.Errorf("expected LIMIT 100 (default after the ceiling check), got query: %s", query)
+	}
+}
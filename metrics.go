@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "chapi_http_request_duration_seconds",
+		Help: "Latency of chapi HTTP handlers.",
+	}, []string{"route", "method", "status"})
+
+	chQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "chapi_clickhouse_query_duration_seconds",
+		Help: "Latency of ClickHouse queries issued by each endpoint.",
+	}, []string{"endpoint"})
+
+	chRowsReturned = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chapi_clickhouse_rows_returned",
+		Help:    "Number of rows a ClickHouse query returned, by endpoint.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	}, []string{"endpoint"})
+
+	chQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chapi_clickhouse_errors_total",
+		Help: "ClickHouse query errors, by ClickHouse exception code.",
+	}, []string{"code"})
+
+	chConnOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chapi_clickhouse_conn_open",
+		Help: "Open connections in the ClickHouse pool.",
+	})
+	chConnIdle = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chapi_clickhouse_conn_idle",
+		Help: "Idle connections in the ClickHouse pool.",
+	})
+)
+
+// statusRecorder captures the status code a handler wrote, since
+// http.ResponseWriter doesn't expose it after the fact.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// instrumentRoute wraps a handler so every request to it reports
+// chapi_http_request_duration_seconds under the given route label.
+func instrumentRoute(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		h(rec, r)
+		httpRequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// recordQueryMetrics reports the ClickHouse-side telemetry for one query
+// issued by endpoint: its duration, row count, and — on failure — an error
+// counter bump.
+func recordQueryMetrics(endpoint string, stats *QueryStats, rowCount int, err error) {
+	chQueryDuration.WithLabelValues(endpoint).Observe(float64(stats.ElapsedMs) / 1000)
+	if err != nil {
+		chQueryErrors.WithLabelValues(errorCode(err)).Inc()
+		return
+	}
+	chRowsReturned.WithLabelValues(endpoint).Observe(float64(rowCount))
+}
+
+// errorCode extracts the ClickHouse exception code from err, if any, so
+// chapi_clickhouse_errors_total can be broken down the same way ClickHouse's
+// own system.errors table is. Errors that never reached the server (a
+// context deadline, a dropped connection) are labeled "client".
+func errorCode(err error) string {
+	var exc *clickhouse.Exception
+	if errors.As(err, &exc) {
+		return strconv.Itoa(int(exc.Code))
+	}
+	return "client"
+}
+
+// startConnStatsCollector periodically publishes conn.Stats() as gauges so
+// "open/idle connection" dashboards don't need to poll the API.
+func startConnStatsCollector(done <-chan struct{}) {
+	ticker := time.NewTicker(15 * time.Second)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				stats := conn.Stats()
+				chConnOpen.Set(float64(stats.Open))
+				chConnIdle.Set(float64(stats.Idle))
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+var metricsHandler = promhttp.Handler()
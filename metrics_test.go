@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+func TestInstrumentRoutePassesStatusThrough(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/facts", nil)
+	rr := httptest.NewRecorder()
+	instrumentRoute("test_route", handler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTeapot {
+		t.Errorf("expected wrapped handler's status to pass through, got %d", rr.Code)
+	}
+}
+
+func TestStatusRecorderDefaultsToOK(t *testing.T) {
+	rr := httptest.NewRecorder()
+	rec := &statusRecorder{ResponseWriter: rr, status: http.StatusOK}
+	if rec.status != http.StatusOK {
+		t.Errorf("expected default status 200, got %d", rec.status)
+	}
+}
+
+func TestRecordQueryMetricsError(t *testing.T) {
+	stats := &QueryStats{ElapsedMs: 12}
+	recordQueryMetrics("test_endpoint", stats, 0, errors.New("boom"))
+}
+
+func TestErrorCode(t *testing.T) {
+	if got := errorCode(errors.New("context deadline exceeded")); got != "client" {
+		t.Errorf("errorCode(plain err) = %q, want client", got)
+	}
+
+	exc := &clickhouse.Exception{Code: 60, Message: "Table doesn't exist"}
+	if got := errorCode(exc); got != "60" {
+		t.Errorf("errorCode(*clickhouse.Exception) = %q, want 60", got)
+	}
+
+	wrapped := fmt.Errorf("query failed: %w", exc)
+	if got := errorCode(wrapped); got != "60" {
+		t.Errorf("errorCode(wrapped exception) = %q, want 60", got)
+	}
+}
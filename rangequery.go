@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// rangeExprRe parses the small PromQL-like subset this package supports:
+//
+//	agg(selector{label="value",...}[range]) [by (col, ...)]
+//
+// selector is either the literal column "metric_value" (aggregate the raw
+// measurement) or any other token, which is matched against the metric_name
+// column. The bracketed range is required for "rate" and optional for every
+// other function. An instant query (handleQueryInstant) evaluates over that
+// declared range, defaulting to one minute when it's absent; a range query
+// (handleQueryRange) always evaluates each point over one step, so rate()
+// there normalizes by step, not by the declared range.
+var rangeExprRe = regexp.MustCompile(`^\s*(\w+)\(\s*([\w.]+)(?:\{([^}]*)\})?(?:\[(\w+)\])?\s*\)(?:\s+by\s*\(\s*([^)]*)\s*\))?\s*$`)
+
+var rangeAggExprs = map[string]string{
+	"sum":   "sum(metric_value)",
+	"avg":   "avg(metric_value)",
+	"count": "count()",
+	"min":   "min(metric_value)",
+	"max":   "max(metric_value)",
+	"uniq":  "uniq(user_id)",
+	"rate":  "sum(metric_value)",
+}
+
+// rangeExpr is the parsed form of a query= expression.
+type rangeExpr struct {
+	Func    string
+	Metric  string
+	Labels  map[string]string
+	GroupBy []string
+	IsRate  bool
+	Range   time.Duration // the bracketed [5m]-style window; set whenever one is declared, required for rate()
+}
+
+func parseRangeExpr(expr string) (*rangeExpr, error) {
+	m := rangeExprRe.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("could not parse query expression: %q", expr)
+	}
+	fn := m[1]
+	if _, ok := rangeAggExprs[fn]; !ok {
+		return nil, fmt.Errorf("unsupported aggregation function: %q", fn)
+	}
+
+	re := &rangeExpr{Func: fn, Metric: m[2], IsRate: fn == "rate"}
+
+	if rangeStr := strings.TrimSpace(m[4]); rangeStr != "" {
+		rangeDur, err := parseStep(rangeStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range: %q", rangeStr)
+		}
+		re.Range = rangeDur
+	} else if re.IsRate {
+		return nil, fmt.Errorf("rate() requires a range vector, e.g. rate(metric_value[5m])")
+	}
+
+	if labelStr := strings.TrimSpace(m[3]); labelStr != "" {
+		re.Labels = make(map[string]string)
+		for _, pair := range strings.Split(labelStr, ",") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid label filter: %q", pair)
+			}
+			key := strings.TrimSpace(kv[0])
+			val := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			if !allowedCols[key] {
+				return nil, fmt.Errorf("invalid label column: %q", key)
+			}
+			re.Labels[key] = val
+		}
+	}
+
+	if groupStr := strings.TrimSpace(m[5]); groupStr != "" {
+		for _, col := range strings.Split(groupStr, ",") {
+			col = strings.TrimSpace(col)
+			if !allowedCols[col] {
+				return nil, fmt.Errorf("invalid group_by column: %q", col)
+			}
+			re.GroupBy = append(re.GroupBy, col)
+		}
+	}
+
+	return re, nil
+}
+
+// allowedCols whitelists columns that may appear in label filters or group by
+// clauses of a range/instant query, mirroring aggregateAllowedCols.
+var allowedCols = map[string]bool{
+	"event_date": true, "event_type": true, "metric_name": true,
+	"user_id": true, "session_id": true,
+}
+
+// parseStep parses a Prometheus-style duration like "30s", "5m", "1h", "1d".
+func parseStep(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("step is required")
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid step: %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func parseRangeTime(s string) (time.Time, error) {
+	if ts, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(int64(ts), 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// matrixSeries is one series of a Prometheus-style range query result.
+type matrixSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]any          `json:"values"`
+}
+
+type matrixResult struct {
+	ResultType string         `json:"resultType"`
+	Result     []matrixSeries `json:"result"`
+}
+
+type vectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]any            `json:"value"`
+}
+
+type vectorResult struct {
+	ResultType string         `json:"resultType"`
+	Result     []vectorSample `json:"result"`
+}
+
+// GET /api/facts/query_range?query=...&start=...&end=...&step=30s
+func handleQueryRange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, APIResponse{Error: "method not allowed"})
+		return
+	}
+
+	q := r.URL.Query()
+	re, err := parseRangeExpr(q.Get("query"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{Error: err.Error()})
+		return
+	}
+
+	start, err := parseRangeTime(q.Get("start"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{Error: "invalid start: " + err.Error()})
+		return
+	}
+	end, err := parseRangeTime(q.Get("end"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{Error: "invalid end: " + err.Error()})
+		return
+	}
+	step, err := parseStep(q.Get("step"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{Error: err.Error()})
+		return
+	}
+	stepSeconds := int64(step.Seconds())
+	if stepSeconds <= 0 {
+		writeJSON(w, http.StatusBadRequest, APIResponse{Error: "step must be positive"})
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	if et, ok := re.Labels["event_type"]; ok && !allowedEventType(claims, et) {
+		writeJSON(w, http.StatusForbidden, APIResponse{Error: "event_type not permitted for this token"})
+		return
+	}
+
+	query, args := buildRangeQuery(re, start, end, stepSeconds, claims)
+
+	ctx, cancel := requestContext(r)
+	defer cancel()
+
+	stats := &QueryStats{}
+	includeStats := wantsStats(r, "")
+
+	var rows driver.Rows
+	err = measureQuery(ctx, stats, func(qctx context.Context) error {
+		var qerr error
+		rows, qerr = conn.Query(qctx, query, args...)
+		return qerr
+	})
+	if err != nil {
+		recordQueryMetrics("query_range", stats, 0, err)
+		writeJSON(w, http.StatusInternalServerError, APIResponse{Error: err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	seriesByKey := make(map[string]*matrixSeries)
+	var order []string
+	var rowCount int
+
+	for rows.Next() {
+		var bucket time.Time
+		var value float64
+		labelVals := make([]string, len(re.GroupBy))
+		dest := []any{&bucket, &value}
+		for i := range labelVals {
+			dest = append(dest, &labelVals[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			writeJSON(w, http.StatusInternalServerError, APIResponse{Error: err.Error()})
+			return
+		}
+		rowCount++
+		// Each bucket sums exactly stepSeconds worth of rows (see the
+		// toStartOfInterval GROUP BY below), so normalizing by anything but
+		// the bucket width - in particular by the declared [range], which is
+		// usually wider - misstates the per-second rate.
+		if re.IsRate {
+			value /= float64(stepSeconds)
+		}
+
+		metric := make(map[string]string, len(re.GroupBy))
+		for i, col := range re.GroupBy {
+			metric[col] = labelVals[i]
+		}
+		key := seriesKey(metric)
+
+		s, ok := seriesByKey[key]
+		if !ok {
+			s = &matrixSeries{Metric: metric}
+			seriesByKey[key] = s
+			order = append(order, key)
+		}
+		s.Values = append(s.Values, [2]any{bucket.Unix(), strconv.FormatFloat(value, 'f', -1, 64)})
+	}
+
+	result := matrixResult{ResultType: "matrix"}
+	for _, k := range order {
+		result.Result = append(result.Result, *seriesByKey[k])
+	}
+
+	recordQueryMetrics("query_range", stats, rowCount, nil)
+
+	resp := APIResponse{Data: result, Count: len(result.Result)}
+	if includeStats {
+		resp.Stats = stats
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GET /api/facts/query?query=...&time=...
+func handleQueryInstant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, APIResponse{Error: "method not allowed"})
+		return
+	}
+
+	q := r.URL.Query()
+	re, err := parseRangeExpr(q.Get("query"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{Error: err.Error()})
+		return
+	}
+
+	at := time.Now().UTC()
+	if ts := q.Get("time"); ts != "" {
+		at, err = parseRangeTime(ts)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, APIResponse{Error: "invalid time: " + err.Error()})
+			return
+		}
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	if et, ok := re.Labels["event_type"]; ok && !allowedEventType(claims, et) {
+		writeJSON(w, http.StatusForbidden, APIResponse{Error: "event_type not permitted for this token"})
+		return
+	}
+
+	query, args := buildInstantQuery(re, at, claims)
+
+	ctx, cancel := requestContext(r)
+	defer cancel()
+
+	stats := &QueryStats{}
+	includeStats := wantsStats(r, "")
+
+	var rows driver.Rows
+	err = measureQuery(ctx, stats, func(qctx context.Context) error {
+		var qerr error
+		rows, qerr = conn.Query(qctx, query, args...)
+		return qerr
+	})
+	if err != nil {
+		recordQueryMetrics("query", stats, 0, err)
+		writeJSON(w, http.StatusInternalServerError, APIResponse{Error: err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var samples []vectorSample
+	for rows.Next() {
+		var value float64
+		labelVals := make([]string, len(re.GroupBy))
+		dest := []any{&value}
+		for i := range labelVals {
+			dest = append(dest, &labelVals[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			writeJSON(w, http.StatusInternalServerError, APIResponse{Error: err.Error()})
+			return
+		}
+		if re.IsRate {
+			value /= instantLookback(re).Seconds()
+		}
+
+		metric := make(map[string]string, len(re.GroupBy))
+		for i, col := range re.GroupBy {
+			metric[col] = labelVals[i]
+		}
+		samples = append(samples, vectorSample{Metric: metric, Value: [2]any{at.Unix(), strconv.FormatFloat(value, 'f', -1, 64)}})
+	}
+
+	recordQueryMetrics("query", stats, len(samples), nil)
+
+	result := vectorResult{ResultType: "vector", Result: samples}
+	resp := APIResponse{Data: result, Count: len(samples)}
+	if includeStats {
+		resp.Stats = stats
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func buildRangeQuery(re *rangeExpr, start, end time.Time, stepSeconds int64, claims *Claims) (string, []any) {
+	var selectParts []string
+	selectParts = append(selectParts,
+		fmt.Sprintf("toStartOfInterval(event_time, INTERVAL %d SECOND) AS bucket", stepSeconds),
+		rangeAggExprs[re.Func]+" AS value")
+	selectParts = append(selectParts, re.GroupBy...)
+
+	conditions, args := rangeConditions(re, start, end, claims)
+
+	query := "SELECT " + strings.Join(selectParts, ", ") + " FROM facts WHERE " + strings.Join(conditions, " AND ")
+	query += " GROUP BY bucket" + groupBySuffix(re.GroupBy)
+	query += " ORDER BY " + strings.Join(append(append([]string{}, re.GroupBy...), "bucket"), ", ")
+	query += fmt.Sprintf(" WITH FILL FROM toStartOfInterval(?, INTERVAL %d SECOND) TO toStartOfInterval(?, INTERVAL %d SECOND) STEP %d",
+		stepSeconds, stepSeconds, stepSeconds)
+	args = append(args, start, end)
+
+	return query, args
+}
+
+// defaultInstantLookback is the evaluation window for an instant query whose
+// expression didn't declare a [range] (rate() always declares one).
+const defaultInstantLookback = time.Minute
+
+// instantLookback is the window an instant query evaluates over: the
+// expression's declared [range] if it has one, else defaultInstantLookback.
+func instantLookback(re *rangeExpr) time.Duration {
+	if re.Range > 0 {
+		return re.Range
+	}
+	return defaultInstantLookback
+}
+
+func buildInstantQuery(re *rangeExpr, at time.Time, claims *Claims) (string, []any) {
+	var selectParts []string
+	selectParts = append(selectParts, rangeAggExprs[re.Func]+" AS value")
+	selectParts = append(selectParts, re.GroupBy...)
+
+	// An instant query evaluates over the single interval ending at "at",
+	// sized to the expression's declared [range] when it has one.
+	conditions, args := rangeConditions(re, at.Add(-instantLookback(re)), at, claims)
+
+	query := "SELECT " + strings.Join(selectParts, ", ") + " FROM facts WHERE " + strings.Join(conditions, " AND ")
+	if len(re.GroupBy) > 0 {
+		query += " GROUP BY " + strings.Join(re.GroupBy, ", ")
+	}
+
+	return query, args
+}
+
+func rangeConditions(re *rangeExpr, start, end time.Time, claims *Claims) ([]string, []any) {
+	conditions := []string{"event_time >= ?", "event_time <= ?"}
+	args := []any{start, end}
+
+	if claims != nil {
+		conditions = append(conditions, "tenant_id = ?")
+		args = append(args, claims.TenantID)
+	}
+	if re.Metric != "metric_value" {
+		conditions = append(conditions, "metric_name = ?")
+		args = append(args, re.Metric)
+	}
+	for _, col := range sortedKeys(re.Labels) {
+		conditions = append(conditions, col+" = ?")
+		args = append(args, re.Labels[col])
+	}
+	return conditions, args
+}
+
+func groupBySuffix(groupBy []string) string {
+	if len(groupBy) == 0 {
+		return ""
+	}
+	return ", " + strings.Join(groupBy, ", ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j-1] > keys[j]; j-- {
+			keys[j-1], keys[j] = keys[j], keys[j-1]
+		}
+	}
+	return keys
+}
+
+func seriesKey(metric map[string]string) string {
+	b, _ := json.Marshal(metric)
+	return string(b)
+}
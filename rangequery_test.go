@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRangeExprBasic(t *testing.T) {
+	re, err := parseRangeExpr(`sum(metric_value) by (event_type)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if re.Func != "sum" || re.Metric != "metric_value" {
+		t.Errorf("got func=%s metric=%s", re.Func, re.Metric)
+	}
+	if len(re.GroupBy) != 1 || re.GroupBy[0] != "event_type" {
+		t.Errorf("unexpected group by: %v", re.GroupBy)
+	}
+}
+
+func TestParseRangeExprRateWithFilters(t *testing.T) {
+	re, err := parseRangeExpr(`rate(metric_name{event_type="click"}[5m])`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !re.IsRate {
+		t.Error("expected IsRate to be true")
+	}
+	if re.Labels["event_type"] != "click" {
+		t.Errorf("unexpected labels: %v", re.Labels)
+	}
+	if re.Range != 5*time.Minute {
+		t.Errorf("Range = %v, want 5m", re.Range)
+	}
+}
+
+func TestParseRangeExprInvalid(t *testing.T) {
+	if _, err := parseRangeExpr("not a valid expression"); err == nil {
+		t.Error("expected error for malformed expression")
+	}
+	if _, err := parseRangeExpr("bogus(metric_value)"); err == nil {
+		t.Error("expected error for unsupported function")
+	}
+}
+
+func TestParseRangeExprRateRequiresRange(t *testing.T) {
+	if _, err := parseRangeExpr(`rate(metric_value)`); err == nil {
+		t.Error("expected error for rate() without a [range]")
+	}
+}
+
+func TestInstantLookbackUsesDeclaredRange(t *testing.T) {
+	re, err := parseRangeExpr(`rate(metric_value[1h])`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := instantLookback(re); got != time.Hour {
+		t.Errorf("instantLookback = %v, want 1h", got)
+	}
+
+	re, err = parseRangeExpr(`sum(metric_value)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := instantLookback(re); got != defaultInstantLookback {
+		t.Errorf("instantLookback = %v, want default %v", got, defaultInstantLookback)
+	}
+}
+
+func TestParseStep(t *testing.T) {
+	cases := map[string]time.Duration{
+		"30s": 30 * time.Second,
+		"5m":  5 * time.Minute,
+		"1h":  time.Hour,
+		"1d":  24 * time.Hour,
+	}
+	for in, want := range cases {
+		got, err := parseStep(in)
+		if err != nil {
+			t.Fatalf("parseStep(%q) failed: %v", in, err)
+		}
+		if got != want {
+			t.Errorf("parseStep(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestHandleQueryRangeMethodNotAllowed(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/api/facts/query_range", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handleQueryRange).ServeHTTP(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestHandleQueryRangeInvalidQuery(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/facts/query_range?query=nope&start=2024-01-01T00:00:00Z&end=2024-01-02T00:00:00Z&step=1h", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handleQueryRange).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ruleValue carries no tenant label. /metrics is scraped without per-tenant
+// authorization, so every rule's latest value is visible to any caller with a
+// valid token, and same-named rules of different tenants write to the same
+// series (the tenant map key below only keeps their schedules apart).
+// GET /api/facts/rules lists a tenant's own rule definitions; it does not
+// return their values.
+var ruleValue = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "chapi_rule_value",
+	Help: "Latest value of a saved aggregate rule registered via /api/facts/rules. Not tenant-scoped.",
+}, []string{"rule", "metric", "group"})
+
+// Rule is a saved aggregate query, evaluated on a schedule and re-exported as
+// a chapi_rule_value gauge so dashboards can scrape it without hitting the
+// JSON API on every refresh.
+type Rule struct {
+	Name     string       `json:"name"`
+	Query    QueryRequest `json:"query"`
+	Interval string       `json:"interval"` // e.g. "30s"
+
+	// claims is the caller's token at registration time, so evaluate scopes
+	// the rule exactly like an ad-hoc /api/facts/aggregate call would.
+	// Unexported: never serialized back out via the GET /api/facts/rules listing.
+	claims *Claims
+}
+
+// ruleManager owns the set of registered rules and the goroutines evaluating
+// them on their configured interval. Rules are keyed by (tenant, name), so
+// two tenants can use the same rule name without one silently replacing the
+// other's schedule.
+type ruleManager struct {
+	mu      sync.Mutex
+	rules   map[string]*Rule
+	cancels map[string]context.CancelFunc
+}
+
+func newRuleManager() *ruleManager {
+	return &ruleManager{
+		rules:   make(map[string]*Rule),
+		cancels: make(map[string]context.CancelFunc),
+	}
+}
+
+// tenantOf returns the tenant a rule was registered under, or "" if it was
+// registered without claims (e.g. no bearer token enforced in this deployment).
+func tenantOf(rule *Rule) string {
+	if rule.claims == nil {
+		return ""
+	}
+	return rule.claims.TenantID
+}
+
+func ruleKey(tenantID, name string) string {
+	return tenantID + "\x00" + name
+}
+
+// Register starts (or restarts, if the same tenant already has a rule with
+// this name) a goroutine that evaluates rule every interval until ctx is
+// cancelled or the rule is replaced.
+func (rm *ruleManager) Register(ctx context.Context, rule *Rule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	interval, err := time.ParseDuration(rule.Interval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid interval: %q", rule.Interval)
+	}
+	if _, _, err := buildAggregateQuery(&rule.Query, rule.claims); err != nil {
+		return fmt.Errorf("invalid query: %w", err)
+	}
+
+	key := ruleKey(tenantOf(rule), rule.Name)
+
+	rm.mu.Lock()
+	if cancel, ok := rm.cancels[key]; ok {
+		cancel()
+	}
+	ruleCtx, cancel := context.WithCancel(ctx)
+	rm.rules[key] = rule
+	rm.cancels[key] = cancel
+	rm.mu.Unlock()
+
+	go rm.run(ruleCtx, rule, interval)
+	return nil
+}
+
+// List returns the rules registered under tenantID.
+func (rm *ruleManager) List(tenantID string) []*Rule {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	var rules []*Rule
+	for _, rule := range rm.rules {
+		if tenantOf(rule) == tenantID {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (rm *ruleManager) run(ctx context.Context, rule *Rule, interval time.Duration) {
+	rm.evaluate(ctx, rule)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rm.evaluate(ctx, rule)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (rm *ruleManager) evaluate(ctx context.Context, rule *Rule) {
+	query, args, err := buildAggregateQuery(&rule.Query, rule.claims)
+	if err != nil {
+		log.Printf("rule %s: %v", rule.Name, err)
+		return
+	}
+
+	qctx, cancel := context.WithTimeout(ctx, defaultQueryTimeout)
+	defer cancel()
+
+	rows, err := conn.Query(qctx, query, args...)
+	if err != nil {
+		log.Printf("rule %s: query failed: %v", rule.Name, err)
+		return
+	}
+	defer rows.Close()
+
+	colTypes := rows.ColumnTypes()
+	colNames := rows.Columns()
+
+	for rows.Next() {
+		vals := make([]any, len(colNames))
+		for i, ct := range colTypes {
+			vals[i] = reflect(ct)
+		}
+		if err := rows.Scan(vals...); err != nil {
+			log.Printf("rule %s: scan failed: %v", rule.Name, err)
+			return
+		}
+		row := make(map[string]any)
+		for i, name := range colNames {
+			row[name] = deref(vals[i])
+		}
+
+		groupKey := ruleGroupKey(row, rule.Query.GroupBy)
+		for _, metric := range rule.Query.Metrics {
+			val, ok := numericValue(row[metric])
+			if !ok {
+				continue
+			}
+			ruleValue.WithLabelValues(rule.Name, metric, groupKey).Set(val)
+		}
+	}
+}
+
+func numericValue(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+func ruleGroupKey(row map[string]any, groupBy []string) string {
+	labels := make(map[string]any, len(groupBy))
+	for _, col := range groupBy {
+		labels[col] = row[col]
+	}
+	b, _ := json.Marshal(labels)
+	return string(b)
+}
+
+// POST /api/facts/rules registers (or replaces) a saved aggregate rule.
+// GET /api/facts/rules lists the currently registered rules.
+func handleRules(rm *ruleManager, ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			claims, _ := claimsFromContext(r.Context())
+			var tenantID string
+			if claims != nil {
+				tenantID = claims.TenantID
+			}
+			rules := rm.List(tenantID)
+			writeJSON(w, http.StatusOK, APIResponse{Data: rules, Count: len(rules)})
+		case http.MethodPost:
+			var rule Rule
+			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+				writeJSON(w, http.StatusBadRequest, APIResponse{Error: "invalid request body"})
+				return
+			}
+			rule.claims, _ = claimsFromContext(r.Context())
+			if err := rm.Register(ctx, &rule); err != nil {
+				status := http.StatusBadRequest
+				if errors.Is(err, ErrEventTypeForbidden) {
+					status = http.StatusForbidden
+				}
+				writeJSON(w, status, APIResponse{Error: err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusCreated, APIResponse{Data: rule})
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, APIResponse{Error: "method not allowed"})
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRuleManagerRegisterValidatesName(t *testing.T) {
+	rm := newRuleManager()
+	err := rm.Register(context.Background(), &Rule{Interval: "30s", Query: QueryRequest{Metrics: []string{"metric_value"}}})
+	if err == nil {
+		t.Error("expected error for missing rule name")
+	}
+}
+
+func TestRuleManagerRegisterValidatesInterval(t *testing.T) {
+	rm := newRuleManager()
+	err := rm.Register(context.Background(), &Rule{Name: "bad-interval", Interval: "not-a-duration", Query: QueryRequest{Metrics: []string{"metric_value"}}})
+	if err == nil {
+		t.Error("expected error for unparseable interval")
+	}
+
+	err = rm.Register(context.Background(), &Rule{Name: "zero-interval", Interval: "0s", Query: QueryRequest{Metrics: []string{"metric_value"}}})
+	if err == nil {
+		t.Error("expected error for non-positive interval")
+	}
+}
+
+func TestRuleManagerRegisterValidatesQuery(t *testing.T) {
+	rm := newRuleManager()
+	err := rm.Register(context.Background(), &Rule{Name: "bad-metric", Interval: "30s", Query: QueryRequest{Metrics: []string{"not_a_real_metric"}}})
+	if err == nil {
+		t.Error("expected error for an invalid metric")
+	}
+}
+
+// TestRuleManagerListScopesByTenant inserts directly into the manager's map
+// rather than going through Register, since Register starts a goroutine that
+// evaluates the rule against the package-level ClickHouse conn, which is nil
+// outside of main().
+func TestRuleManagerListScopesByTenant(t *testing.T) {
+	rm := newRuleManager()
+	ruleA := &Rule{Name: "daily-total", claims: &Claims{TenantID: "tenant-a"}}
+	ruleB := &Rule{Name: "daily-total", claims: &Claims{TenantID: "tenant-b"}}
+	rm.rules[ruleKey("tenant-a", ruleA.Name)] = ruleA
+	rm.rules[ruleKey("tenant-b", ruleB.Name)] = ruleB
+
+	listA := rm.List("tenant-a")
+	if len(listA) != 1 || listA[0] != ruleA {
+		t.Errorf("List(tenant-a) = %v, want only ruleA", listA)
+	}
+
+	listB := rm.List("tenant-b")
+	if len(listB) != 1 || listB[0] != ruleB {
+		t.Errorf("List(tenant-b) = %v, want only ruleB", listB)
+	}
+}
+
+func TestTenantOf(t *testing.T) {
+	if got := tenantOf(&Rule{}); got != "" {
+		t.Errorf("tenantOf(no claims) = %q, want empty", got)
+	}
+	if got := tenantOf(&Rule{claims: &Claims{TenantID: "tenant-a"}}); got != "tenant-a" {
+		t.Errorf("tenantOf(claims) = %q, want tenant-a", got)
+	}
+}
+
+func TestRuleGroupKey(t *testing.T) {
+	row := map[string]any{"event_type": "click", "region": "US"}
+	a := ruleGroupKey(row, []string{"event_type", "region"})
+	b := ruleGroupKey(row, []string{"event_type", "region"})
+	if a != b {
+		t.Errorf("expected ruleGroupKey to be deterministic for the same input, got %q and %q", a, b)
+	}
+}
+
+func TestNumericValue(t *testing.T) {
+	if v, ok := numericValue(float64(3.5)); !ok || v != 3.5 {
+		t.Errorf("numericValue(float64) = %v, %v", v, ok)
+	}
+	if v, ok := numericValue(uint64(7)); !ok || v != 7 {
+		t.Errorf("numericValue(uint64) = %v, %v", v, ok)
+	}
+	if _, ok := numericValue("nope"); ok {
+		t.Error("expected numericValue to reject non-numeric types")
+	}
+}
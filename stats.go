@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// QueryStats surfaces per-query telemetry pulled from ClickHouse's progress
+// and profile-event callbacks, mirroring the "stats=all" param of Prometheus'
+// HTTP API.
+type QueryStats struct {
+	RowsRead        uint64 `json:"rows_read"`
+	BytesRead       uint64 `json:"bytes_read"`
+	ElapsedMs       int64  `json:"elapsed_ms"`
+	PeakMemoryUsage int64  `json:"peak_memory_usage"`
+}
+
+// wantsStats reports whether the caller asked for query stats via
+// ?stats=all (GET handlers) or a "stats":"all" field in a POST body.
+func wantsStats(r *http.Request, bodyStats string) bool {
+	return r.URL.Query().Get("stats") == "all" || bodyStats == "all"
+}
+
+// withStats wires ClickHouse progress/profile-event callbacks into ctx so
+// stats is populated as the query executes. The caller is responsible for
+// recording ElapsedMs once the query finishes.
+func withStats(ctx context.Context, stats *QueryStats) context.Context {
+	return clickhouse.Context(ctx,
+		clickhouse.WithProgress(func(p *clickhouse.Progress) {
+			stats.RowsRead += p.Rows
+			stats.BytesRead += p.Bytes
+		}),
+		clickhouse.WithProfileEvents(func(events []clickhouse.ProfileEvent) {
+			for _, e := range events {
+				if e.Name != "MemoryTrackerPeakUsageForUser" && e.Name != "PeakMemoryUsage" {
+					continue
+				}
+				if e.Value > stats.PeakMemoryUsage {
+					stats.PeakMemoryUsage = e.Value
+				}
+			}
+		}),
+	)
+}
+
+// measureQuery runs fn with stats wired in and records how long it took.
+func measureQuery(ctx context.Context, stats *QueryStats, fn func(context.Context) error) error {
+	start := time.Now()
+	err := fn(withStats(ctx, stats))
+	stats.ElapsedMs = time.Since(start).Milliseconds()
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestWantsStats(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/facts?stats=all", nil)
+	if !wantsStats(req, "") {
+		t.Error("expected wantsStats to be true for ?stats=all")
+	}
+
+	req, _ = http.NewRequest("POST", "/api/facts/aggregate", nil)
+	if !wantsStats(req, "all") {
+		t.Error("expected wantsStats to be true for body stats=all")
+	}
+
+	req, _ = http.NewRequest("GET", "/api/facts", nil)
+	if wantsStats(req, "") {
+		t.Error("expected wantsStats to be false by default")
+	}
+}
+
+func TestRequestContextDefaultTimeout(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/facts", nil)
+	ctx, cancel := requestContext(req)
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("expected requestContext to set a deadline")
+	}
+}
+
+func TestRequestContextCustomTimeout(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/facts?timeout=500ms", nil)
+	ctx, cancel := requestContext(req)
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("expected requestContext to set a deadline")
+	}
+	if deadline.IsZero() {
+		t.Error("expected non-zero deadline")
+	}
+}